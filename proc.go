@@ -0,0 +1,178 @@
+package proc
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProcessStats is the extended set of per-process metrics returned by
+// Stat, covering CPU, memory, I/O, and scheduling details beyond the
+// pcpu/rss/vss trio Usage exposes.
+type ProcessStats struct {
+	CPUUserTime   time.Duration
+	CPUSystemTime time.Duration
+	// PercentCPU is ps-style on Linux, Darwin, FreeBSD, OpenBSD, and
+	// AIX: 100 means one fully busy core, so a process pegging one core
+	// on an N-core machine reads ~100 regardless of N. On Windows it is
+	// normalized by NumberOfProcessors instead, matching Task Manager:
+	// the same process there reads ~100/N. Callers comparing this value
+	// across platforms need to divide by core count themselves on the
+	// non-Windows platforms to get a like-for-like figure.
+	PercentCPU             float64
+	RSS                    int64
+	VSS                    int64
+	SharedMem              int64
+	Threads                int
+	OpenFDs                int
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+	IORead                 int64
+	IOWrite                int64
+	StartTime              time.Time
+	State                  string
+	Cmdline                string
+}
+
+// CPUTimes holds cumulative CPU tick/millisecond counters for a single
+// CPU (or the system-wide aggregate), in the same units /proc/stat
+// reports them in on Linux. On Windows, SystemCPU fills in User with a
+// percent-busy sample and Idle with 100 minus that, leaving the other
+// fields at zero.
+type CPUTimes struct {
+	User    int64
+	Nice    int64
+	System  int64
+	Idle    int64
+	IOWait  int64
+	IRQ     int64
+	SoftIRQ int64
+	Steal   int64
+}
+
+// SystemCPUStats is the return value of SystemCPU: the system-wide
+// aggregate plus one entry per logical CPU.
+type SystemCPUStats struct {
+	Total  CPUTimes
+	PerCPU []CPUTimes
+}
+
+// MemStats is the return value of SystemMem, covering both physical and
+// swap memory.
+type MemStats struct {
+	Total     int64
+	Free      int64
+	Available int64
+	Buffers   int64
+	Cached    int64
+	SwapTotal int64
+	SwapFree  int64
+}
+
+// LoadAvg is the return value of LoadAverage.
+type LoadAvg struct {
+	Load1  float64
+	Load5  float64
+	Load15 float64
+}
+
+// DiskStats is one entry of the slice returned by DiskIO, covering a
+// single block device.
+type DiskStats struct {
+	Device     string
+	ReadBytes  int64
+	WriteBytes int64
+	ReadOps    int64
+	WriteOps   int64
+}
+
+// NetStats is one entry of the slice returned by NetIO, covering a
+// single network interface.
+type NetStats struct {
+	Interface string
+	RxBytes   int64
+	TxBytes   int64
+	RxPackets int64
+	TxPackets int64
+}
+
+// maxPidSamples bounds the number of distinct (source, pid, start)
+// triples we keep last-sample state for, evicting the least recently
+// used once exceeded.
+const maxPidSamples = 256
+
+// sampleSource distinguishes independent consumers of pcpuFromSample so
+// that, say, a Sampler polling a pid in the background doesn't disturb
+// the measurement window a direct Stat/Usage caller for the same pid is
+// relying on. directSample is the keyspace Stat and Usage use; each
+// Sampler gets its own via newSampleSource.
+type sampleSource int64
+
+const directSample sampleSource = 0
+
+var nextSampleSource int64
+
+// newSampleSource returns a sampleSource distinct from directSample and
+// from every other value newSampleSource has returned.
+func newSampleSource() sampleSource {
+	return sampleSource(atomic.AddInt64(&nextSampleSource, 1))
+}
+
+type pidSampleKey struct {
+	source sampleSource
+	pid    int
+	start  int64
+}
+
+type pidSample struct {
+	total int64
+	wall  int64
+}
+
+var (
+	pidSamplesMu   sync.Mutex
+	pidSamples     = map[pidSampleKey]*pidSample{}
+	pidSampleLRU   = list.New()
+	pidSampleElems = map[pidSampleKey]*list.Element{}
+)
+
+// pcpuFromSample updates the last-sample state for (source, pid, start)
+// with a new cumulative cpu-time total in nanoseconds and returns the
+// instantaneous CPU usage since the previous sample, in tenths of a
+// percent. Keying on start (a platform-specific process start
+// identifier, e.g. boot-relative ticks or a creation timestamp) keeps a
+// reused pid from aliasing onto a short-lived predecessor's state.
+func pcpuFromSample(source sampleSource, pid int, start, totalNs int64) int64 {
+	key := pidSampleKey{source, pid, start}
+	now := time.Now().UnixNano()
+
+	pidSamplesMu.Lock()
+	defer pidSamplesMu.Unlock()
+
+	if elem, ok := pidSampleElems[key]; ok {
+		pidSampleLRU.MoveToFront(elem)
+	} else {
+		pidSampleElems[key] = pidSampleLRU.PushFront(key)
+		for pidSampleLRU.Len() > maxPidSamples {
+			oldest := pidSampleLRU.Back()
+			pidSampleLRU.Remove(oldest)
+			k := oldest.Value.(pidSampleKey)
+			delete(pidSampleElems, k)
+			delete(pidSamples, k)
+		}
+	}
+
+	prev, had := pidSamples[key]
+	pidSamples[key] = &pidSample{total: totalNs, wall: now}
+
+	if !had {
+		return 0
+	}
+
+	wall := now - prev.wall
+	if wall <= 0 {
+		return 0
+	}
+	return (totalNs - prev.total) * 1000 / wall
+}
@@ -5,91 +5,141 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"sync/atomic"
-	"syscall"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-var (
-	procStatFile string
-	ticks        int64
-	lastTotal    int64
-	lastSeconds  int64
-	ipcpu        int64
-)
+var ticks int64
 
 const (
-	utimePos = 13
-	stimePos = 14
-	startPos = 21
-	vssPos   = 22
-	rssPos   = 23
+	statePos   = 2
+	utimePos   = 13
+	stimePos   = 14
+	threadsPos = 19
+	startPos   = 21
+	vssPos     = 22
+	rssPos     = 23
 )
 
 func init() {
 	// This will avoid to generate docker image without CGO
 	ticks = int64(100) // int64(C.sysconf(C._SC_CLK_TCK))
-	procStatFile = fmt.Sprintf("/proc/%d/stat", os.Getpid())
-	periodic()
 }
 
-// Sampling function to keep pcpu relevant.
-func periodic() {
-	contents, err := ioutil.ReadFile(procStatFile)
+// Usage returns CPU and Memory usage
+func Usage(pcpu *float64, rss, vss *int64) error {
+	st, err := Stat(os.Getpid())
 	if err != nil {
-		return
+		return err
 	}
-	fields := bytes.Fields(contents)
-
-	// PCPU
-	pstart := parseInt64(fields[startPos])
-	utime := parseInt64(fields[utimePos])
-	stime := parseInt64(fields[stimePos])
-	total := utime + stime
 
-	var sysinfo syscall.Sysinfo_t
-	if err := syscall.Sysinfo(&sysinfo); err != nil {
-		return
-	}
+	*pcpu = st.PercentCPU
+	*rss = st.RSS
+	*vss = st.VSS
 
-	seconds := sysinfo.Uptime - (pstart / ticks)
+	return nil
+}
 
-	// Save temps
-	lt := lastTotal
-	ls := lastSeconds
+var (
+	bootTimeOnce sync.Once
+	bootTimeSecs int64
+)
 
-	// Update last sample
-	lastTotal = total
-	lastSeconds = seconds
+// getBootTime returns the system boot time, in seconds since the Unix
+// epoch, parsed from the "btime" line of /proc/stat. It is computed
+// once and cached since it cannot change for a running kernel.
+func getBootTime() int64 {
+	bootTimeOnce.Do(func() {
+		contents, err := ioutil.ReadFile("/proc/stat")
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			if strings.HasPrefix(line, "btime ") {
+				bootTimeSecs, _ = strconv.ParseInt(strings.TrimSpace(line[len("btime "):]), 10, 64)
+				return
+			}
+		}
+	})
+	return bootTimeSecs
+}
 
-	// Adjust to current time
-	total -= lt
-	seconds -= ls
+// Stat returns extended process metrics for pid, gathered from
+// /proc/[pid]/stat, /proc/[pid]/status, /proc/[pid]/io, /proc/[pid]/fd,
+// and /proc/[pid]/cmdline.
+func Stat(pid int) (ProcessStats, error) {
+	return statSource(pid, directSample)
+}
 
-	if seconds > 0 {
-		atomic.StoreInt64(&ipcpu, (total*1000/ticks)/seconds)
-	}
+// statSource is Stat, parameterized over the pcpuFromSample keyspace so
+// a Sampler can poll the same pid without perturbing the measurement
+// window a direct Stat/Usage caller for that pid is relying on.
+func statSource(pid int, source sampleSource) (ProcessStats, error) {
+	var st ProcessStats
 
-	time.AfterFunc(1*time.Second, periodic)
-}
+	dir := fmt.Sprintf("/proc/%d", pid)
 
-// Usage returns CPU and Memory usage
-func Usage(pcpu *float64, rss, vss *int64) error {
-	contents, err := ioutil.ReadFile(procStatFile)
+	contents, err := ioutil.ReadFile(dir + "/stat")
 	if err != nil {
-		return err
+		return st, err
 	}
 	fields := bytes.Fields(contents)
 
-	// Memory
-	*rss = (parseInt64(fields[rssPos])) << 12
-	*vss = parseInt64(fields[vssPos])
+	utime := parseInt64(fields[utimePos])
+	stime := parseInt64(fields[stimePos])
+	pstart := parseInt64(fields[startPos])
 
-	// PCPU
-	// We track with periodic sampling.
-	*pcpu = float64(atomic.LoadInt64(&ipcpu)) / 10.0
+	st.CPUUserTime = time.Duration(utime) * time.Second / time.Duration(ticks)
+	st.CPUSystemTime = time.Duration(stime) * time.Second / time.Duration(ticks)
+	st.State = string(fields[statePos])
+	st.Threads = int(parseInt64(fields[threadsPos]))
+	st.RSS = parseInt64(fields[rssPos]) << 12
+	st.VSS = parseInt64(fields[vssPos])
+	st.StartTime = time.Unix(getBootTime()+pstart/ticks, 0)
+
+	total := (utime + stime) * int64(time.Second) / ticks
+	st.PercentCPU = float64(pcpuFromSample(source, pid, pstart, total)) / 10.0
+
+	if statm, err := ioutil.ReadFile(dir + "/statm"); err == nil {
+		f := bytes.Fields(statm)
+		if len(f) > 2 {
+			st.SharedMem = parseInt64(f[2]) << 12
+		}
+	}
 
-	return nil
+	if status, err := ioutil.ReadFile(dir + "/status"); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			switch {
+			case strings.HasPrefix(line, "voluntary_ctxt_switches:"):
+				st.VoluntaryCtxSwitches, _ = strconv.ParseInt(strings.TrimSpace(line[len("voluntary_ctxt_switches:"):]), 10, 64)
+			case strings.HasPrefix(line, "nonvoluntary_ctxt_switches:"):
+				st.InvoluntaryCtxSwitches, _ = strconv.ParseInt(strings.TrimSpace(line[len("nonvoluntary_ctxt_switches:"):]), 10, 64)
+			}
+		}
+	}
+
+	if io, err := ioutil.ReadFile(dir + "/io"); err == nil {
+		for _, line := range strings.Split(string(io), "\n") {
+			switch {
+			case strings.HasPrefix(line, "read_bytes:"):
+				st.IORead, _ = strconv.ParseInt(strings.TrimSpace(line[len("read_bytes:"):]), 10, 64)
+			case strings.HasPrefix(line, "write_bytes:"):
+				st.IOWrite, _ = strconv.ParseInt(strings.TrimSpace(line[len("write_bytes:"):]), 10, 64)
+			}
+		}
+	}
+
+	if fds, err := ioutil.ReadDir(dir + "/fd"); err == nil {
+		st.OpenFDs = len(fds)
+	}
+
+	if cmdline, err := ioutil.ReadFile(dir + "/cmdline"); err == nil {
+		st.Cmdline = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+
+	return st, nil
 }
 
 // Ascii numbers 0-9
@@ -0,0 +1,189 @@
+package proc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metric is a bitmask selecting which groups of ProcessStats fields a
+// Sampler is interested in. It does not change what Stat populates (the
+// full struct is always filled in), but is available to callers
+// configuring a Sampler for documentation/future filtering.
+type Metric int
+
+const (
+	CPU Metric = 1 << iota
+	Memory
+	IO
+)
+
+// SamplerOpts configures a Sampler.
+type SamplerOpts struct {
+	// Interval between samples. Defaults to 1 second if zero.
+	Interval time.Duration
+	// PIDs tracked on every tick.
+	PIDs []int
+	// Metrics the caller cares about; see Metric.
+	Metrics Metric
+}
+
+// Sample is delivered on a Sampler's Subscribe channel each time a
+// tracked PID is resampled.
+type Sample struct {
+	PID       int
+	Timestamp time.Time
+	Stats     ProcessStats
+}
+
+// Sampler periodically calls Stat for a fixed set of PIDs, keeping a
+// snapshot of their latest stats and optionally streaming each update to
+// subscribers.
+type Sampler struct {
+	opts SamplerOpts
+
+	// source gives this Sampler's ticks their own pcpuFromSample
+	// keyspace, separate from direct Stat/Usage callers and from any
+	// other Sampler, so polling here doesn't corrupt the measurement
+	// window those rely on.
+	source sampleSource
+
+	mu       sync.RWMutex
+	snapshot map[int]ProcessStats
+
+	subsMu  sync.Mutex
+	subs    []chan Sample
+	dropped int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSampler creates a Sampler for opts.PIDs. Sampling does not start
+// until Start is called.
+func NewSampler(opts SamplerOpts) *Sampler {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	return &Sampler{
+		opts:     opts,
+		source:   newSampleSource(),
+		snapshot: make(map[int]ProcessStats, len(opts.PIDs)),
+	}
+}
+
+// Start begins sampling in a background goroutine, ticking every
+// opts.Interval until ctx is done or Stop is called.
+func (s *Sampler) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.opts.Interval)
+		defer ticker.Stop()
+
+		s.tick()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.tick()
+			}
+		}
+	}()
+}
+
+// Stop halts sampling, waits for the background goroutine to exit, and
+// closes every channel handed out by Subscribe, so a consumer ranging
+// over one (for s := range ch) unblocks instead of leaking.
+func (s *Sampler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+
+	s.subsMu.Lock()
+	for _, ch := range s.subs {
+		close(ch)
+	}
+	s.subs = nil
+	s.subsMu.Unlock()
+}
+
+func (s *Sampler) tick() {
+	now := time.Now()
+	for _, pid := range s.opts.PIDs {
+		st, err := statSource(pid, s.source)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		s.snapshot[pid] = st
+		s.mu.Unlock()
+
+		s.publish(Sample{PID: pid, Timestamp: now, Stats: st})
+	}
+}
+
+// Snapshot returns the most recently sampled stats for each tracked PID.
+func (s *Sampler) Snapshot() map[int]ProcessStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[int]ProcessStats, len(s.snapshot))
+	for k, v := range s.snapshot {
+		out[k] = v
+	}
+	return out
+}
+
+// Subscribe returns a channel that receives a Sample on every tick.
+// Sends are non-blocking: a slow consumer has its oldest buffered
+// sample dropped (counted in Dropped) rather than stalling the sampler.
+func (s *Sampler) Subscribe() <-chan Sample {
+	ch := make(chan Sample, 16)
+
+	s.subsMu.Lock()
+	s.subs = append(s.subs, ch)
+	s.subsMu.Unlock()
+
+	return ch
+}
+
+// Dropped returns the number of samples dropped across all subscribers
+// because a channel was full.
+func (s *Sampler) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+func (s *Sampler) publish(sample Sample) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	for _, ch := range s.subs {
+		select {
+		case ch <- sample:
+			continue
+		default:
+		}
+
+		// Drop the oldest buffered sample to make room rather than
+		// blocking the sampler on a slow consumer.
+		select {
+		case <-ch:
+			atomic.AddInt64(&s.dropped, 1)
+		default:
+		}
+
+		select {
+		case ch <- sample:
+		default:
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// parseCPULine parses a "cpu" or "cpuN" line from /proc/stat, whose
+// fields are user nice system idle iowait irq softirq steal guest
+// guest_nice, in that order (trailing fields may be absent on older
+// kernels).
+func parseCPULine(fields []string) CPUTimes {
+	var ct CPUTimes
+	vals := make([]int64, 8)
+	for i := 0; i < len(vals) && i+1 < len(fields); i++ {
+		vals[i], _ = strconv.ParseInt(fields[i+1], 10, 64)
+	}
+	ct.User, ct.Nice, ct.System, ct.Idle = vals[0], vals[1], vals[2], vals[3]
+	ct.IOWait, ct.IRQ, ct.SoftIRQ, ct.Steal = vals[4], vals[5], vals[6], vals[7]
+	return ct
+}
+
+// SystemCPU returns per-CPU and aggregate tick counters parsed from
+// /proc/stat.
+func SystemCPU() (SystemCPUStats, error) {
+	var st SystemCPUStats
+
+	contents, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return st, err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] == "cpu" {
+			st.Total = parseCPULine(fields)
+		} else {
+			st.PerCPU = append(st.PerCPU, parseCPULine(fields))
+		}
+	}
+
+	return st, nil
+}
+
+// SystemMem returns system memory stats parsed from /proc/meminfo. All
+// values are in bytes.
+func SystemMem() (MemStats, error) {
+	var mem MemStats
+
+	contents, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return mem, err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		val := kb * 1024
+
+		switch strings.TrimSuffix(fields[0], ":") {
+		case "MemTotal":
+			mem.Total = val
+		case "MemFree":
+			mem.Free = val
+		case "MemAvailable":
+			mem.Available = val
+		case "Buffers":
+			mem.Buffers = val
+		case "Cached":
+			mem.Cached = val
+		case "SwapTotal":
+			mem.SwapTotal = val
+		case "SwapFree":
+			mem.SwapFree = val
+		}
+	}
+
+	return mem, nil
+}
+
+// LoadAverage returns the 1/5/15-minute load averages parsed from
+// /proc/loadavg.
+func LoadAverage() (LoadAvg, error) {
+	var la LoadAvg
+
+	contents, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return la, err
+	}
+
+	fields := strings.Fields(string(contents))
+	if len(fields) < 3 {
+		return la, fmt.Errorf("unexpected /proc/loadavg format: %q", contents)
+	}
+
+	la.Load1, _ = strconv.ParseFloat(fields[0], 64)
+	la.Load5, _ = strconv.ParseFloat(fields[1], 64)
+	la.Load15, _ = strconv.ParseFloat(fields[2], 64)
+
+	return la, nil
+}
+
+// DiskIO returns per-device read/write bytes and ops parsed from
+// /proc/diskstats.
+func DiskIO() ([]DiskStats, error) {
+	contents, err := ioutil.ReadFile("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []DiskStats
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		// device name reads sectors ... writes write sectors ...
+		if len(fields) < 10 {
+			continue
+		}
+
+		readOps, _ := strconv.ParseInt(fields[3], 10, 64)
+		readSectors, _ := strconv.ParseInt(fields[5], 10, 64)
+		writeOps, _ := strconv.ParseInt(fields[7], 10, 64)
+		writeSectors, _ := strconv.ParseInt(fields[9], 10, 64)
+
+		disks = append(disks, DiskStats{
+			Device:     fields[2],
+			ReadOps:    readOps,
+			ReadBytes:  readSectors * 512,
+			WriteOps:   writeOps,
+			WriteBytes: writeSectors * 512,
+		})
+	}
+
+	return disks, nil
+}
+
+// NetIO returns per-interface rx/tx bytes and packets parsed from
+// /proc/net/dev.
+func NetIO() ([]NetStats, error) {
+	contents, err := ioutil.ReadFile("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+
+	var nets []NetStats
+	for _, line := range strings.Split(string(contents), "\n") {
+		if !strings.Contains(line, ":") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		iface := strings.TrimSpace(parts[0])
+		fields := strings.Fields(parts[1])
+		if len(fields) < 16 {
+			continue
+		}
+
+		rxBytes, _ := strconv.ParseInt(fields[0], 10, 64)
+		rxPackets, _ := strconv.ParseInt(fields[1], 10, 64)
+		txBytes, _ := strconv.ParseInt(fields[8], 10, 64)
+		txPackets, _ := strconv.ParseInt(fields[9], 10, 64)
+
+		nets = append(nets, NetStats{
+			Interface: iface,
+			RxBytes:   rxBytes,
+			RxPackets: rxPackets,
+			TxBytes:   txBytes,
+			TxPackets: txPackets,
+		})
+	}
+
+	return nets, nil
+}
@@ -0,0 +1,336 @@
+// +build windows
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// pdhCollectInterval is the delay between the two PdhCollectQueryData
+// calls rate counters (% Processor Time, *Bytes/sec, ...) need: PDH
+// derives the rate from the delta between samples, so back-to-back
+// calls with ~0 elapsed time yield ~0 for every counter.
+const pdhCollectInterval = 200 * time.Millisecond
+
+var (
+	pdh                            = syscall.NewLazyDLL("pdh.dll")
+	winPdhOpenQuery                = pdh.NewProc("PdhOpenQuery")
+	winPdhAddCounter               = pdh.NewProc("PdhAddCounterW")
+	winPdhCollectQueryData         = pdh.NewProc("PdhCollectQueryData")
+	winPdhGetFormattedCounterArray = pdh.NewProc("PdhGetFormattedCounterArrayW")
+
+	procGlobalMemoryStatusEx = modkernel32.NewProc("GlobalMemoryStatusEx")
+)
+
+type (
+	pdhHQuery   syscall.Handle
+	pdhHCounter syscall.Handle
+)
+
+const (
+	pdhFmtDouble   = 0x00000200
+	pdhMoreData    = 0x800007D2
+	maxCounterRows = 512
+)
+
+type pdhFmtCounterValueDouble struct {
+	CStatus     uint32
+	DoubleValue float64
+}
+
+type pdhFmtCounterValueItemDouble struct {
+	SzName   *uint16
+	FmtValue pdhFmtCounterValueDouble
+}
+
+// memoryStatusEx mirrors MEMORYSTATUSEX.
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+func sysPdhOpenQuery() (pdhHQuery, error) {
+	var q pdhHQuery
+	r0, _, _ := syscall.Syscall(winPdhOpenQuery.Addr(), 3, 0, 0, uintptr(unsafe.Pointer(&q)))
+	if r0 != 0 {
+		return 0, fmt.Errorf("PdhOpenQuery failed: %d", r0)
+	}
+	return q, nil
+}
+
+func sysPdhAddCounter(q pdhHQuery, path string) (pdhHCounter, error) {
+	var c pdhHCounter
+	ptxt, _ := syscall.UTF16PtrFromString(path)
+	r0, _, _ := winPdhAddCounter.Call(
+		uintptr(q),
+		uintptr(unsafe.Pointer(ptxt)),
+		0,
+		uintptr(unsafe.Pointer(&c)))
+	if r0 != 0 {
+		return 0, fmt.Errorf("PdhAddCounter(%s) failed: %d", path, r0)
+	}
+	return c, nil
+}
+
+func sysPdhCollectQueryData(q pdhHQuery) error {
+	r0, _, _ := winPdhCollectQueryData.Call(uintptr(q))
+	if r0 != 0 {
+		return fmt.Errorf("PdhCollectQueryData failed: %d", r0)
+	}
+	return nil
+}
+
+// sysPdhCounterArray returns the name/value pairs of an instanced
+// counter, e.g. one row per CPU, disk, or network interface.
+func sysPdhCounterArray(c pdhHCounter) (map[string]float64, error) {
+	var bufSize, bufCount uint32
+	rows := make([]pdhFmtCounterValueItemDouble, 1)
+
+	ret, _, _ := winPdhGetFormattedCounterArray.Call(
+		uintptr(c),
+		uintptr(pdhFmtDouble),
+		uintptr(unsafe.Pointer(&bufSize)),
+		uintptr(unsafe.Pointer(&bufCount)),
+		uintptr(unsafe.Pointer(&rows[0])))
+
+	if uint32(ret) != pdhMoreData {
+		return nil, fmt.Errorf("PdhGetFormattedCounterArray failed: %d", ret)
+	}
+
+	if bufCount > maxCounterRows {
+		bufCount = maxCounterRows
+	}
+
+	// PdhGetFormattedCounterArray reports bufSize in bytes, not
+	// bufCount*sizeof(item): it packs the fixed-size item array followed
+	// by the variable-length instance-name strings each SzName points
+	// into. Allocating bufCount structs instead of bufSize bytes gives
+	// PDH a buffer smaller than what it's about to write, corrupting the
+	// heap past the slice.
+	buf := make([]byte, bufSize)
+
+	ret, _, _ = winPdhGetFormattedCounterArray.Call(
+		uintptr(c),
+		uintptr(pdhFmtDouble),
+		uintptr(unsafe.Pointer(&bufSize)),
+		uintptr(unsafe.Pointer(&bufCount)),
+		uintptr(unsafe.Pointer(&buf[0])))
+	if ret != 0 {
+		return nil, fmt.Errorf("PdhGetFormattedCounterArray failed: %d", ret)
+	}
+
+	items := (*[maxCounterRows]pdhFmtCounterValueItemDouble)(unsafe.Pointer(&buf[0]))
+
+	out := make(map[string]float64, bufCount)
+	for i := 0; i < int(bufCount); i++ {
+		name := syscall.UTF16ToString((*[256]uint16)(unsafe.Pointer(items[i].SzName))[:])
+		out[name] = items[i].FmtValue.DoubleValue
+	}
+	return out, nil
+}
+
+// SystemCPU returns per-CPU and aggregate percent-busy samples from the
+// "\Processor Information(*)\% Processor Time" PDH counter. Two
+// collections a short interval apart are required for PDH to produce a
+// meaningful rate, so this call blocks briefly.
+func SystemCPU() (SystemCPUStats, error) {
+	var st SystemCPUStats
+
+	q, err := sysPdhOpenQuery()
+	if err != nil {
+		return st, err
+	}
+
+	c, err := sysPdhAddCounter(q, `\Processor Information(*)\% Processor Time`)
+	if err != nil {
+		return st, err
+	}
+
+	if err := sysPdhCollectQueryData(q); err != nil {
+		return st, err
+	}
+	time.Sleep(pdhCollectInterval)
+	if err := sysPdhCollectQueryData(q); err != nil {
+		return st, err
+	}
+
+	rows, err := sysPdhCounterArray(c)
+	if err != nil {
+		return st, err
+	}
+
+	for name, busy := range rows {
+		ct := CPUTimes{User: int64(busy), Idle: int64(100 - busy)}
+		if name == "_Total" {
+			st.Total = ct
+		} else if !strings.HasSuffix(name, ",_Total") {
+			// Skip the per-processor-group "N,_Total" rows.
+			st.PerCPU = append(st.PerCPU, ct)
+		}
+	}
+
+	return st, nil
+}
+
+// SystemMem returns system memory stats via GlobalMemoryStatusEx.
+// Windows does not distinguish buffers/cached the way Linux does, so
+// those fields are left at zero.
+func SystemMem() (MemStats, error) {
+	var mem MemStats
+
+	var ms memoryStatusEx
+	ms.Length = uint32(unsafe.Sizeof(ms))
+	r, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&ms)))
+	if r == 0 {
+		return mem, fmt.Errorf("GlobalMemoryStatusEx failed: %v", err)
+	}
+
+	mem.Total = int64(ms.TotalPhys)
+	mem.Free = int64(ms.AvailPhys)
+	mem.Available = int64(ms.AvailPhys)
+	mem.SwapTotal = int64(ms.TotalPageFile - ms.TotalPhys)
+	mem.SwapFree = int64(ms.AvailPageFile - ms.AvailPhys)
+
+	return mem, nil
+}
+
+// LoadAverage has no native equivalent on Windows. We synthesize all
+// three fields from a single "\Processor Information(_Total)\%
+// Processor Time" sample, which callers should treat as a rough
+// approximation rather than a true decaying average.
+func LoadAverage() (LoadAvg, error) {
+	cpu, err := SystemCPU()
+	if err != nil {
+		return LoadAvg{}, err
+	}
+
+	busy := float64(cpu.Total.User) / 100.0
+	return LoadAvg{Load1: busy, Load5: busy, Load15: busy}, nil
+}
+
+// DiskIO returns per-device read/write bytes and ops from the
+// "\PhysicalDisk(*)\..." PDH counters.
+func DiskIO() ([]DiskStats, error) {
+	q, err := sysPdhOpenQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	readCounter, err := sysPdhAddCounter(q, `\PhysicalDisk(*)\Disk Read Bytes/sec`)
+	if err != nil {
+		return nil, err
+	}
+	writeCounter, err := sysPdhAddCounter(q, `\PhysicalDisk(*)\Disk Write Bytes/sec`)
+	if err != nil {
+		return nil, err
+	}
+	readOpsCounter, err := sysPdhAddCounter(q, `\PhysicalDisk(*)\Disk Reads/sec`)
+	if err != nil {
+		return nil, err
+	}
+	writeOpsCounter, err := sysPdhAddCounter(q, `\PhysicalDisk(*)\Disk Writes/sec`)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sysPdhCollectQueryData(q); err != nil {
+		return nil, err
+	}
+	time.Sleep(pdhCollectInterval)
+	if err := sysPdhCollectQueryData(q); err != nil {
+		return nil, err
+	}
+
+	reads, err := sysPdhCounterArray(readCounter)
+	if err != nil {
+		return nil, err
+	}
+	writes, _ := sysPdhCounterArray(writeCounter)
+	readOps, _ := sysPdhCounterArray(readOpsCounter)
+	writeOps, _ := sysPdhCounterArray(writeOpsCounter)
+
+	var disks []DiskStats
+	for name, rb := range reads {
+		if name == "_Total" {
+			continue
+		}
+		disks = append(disks, DiskStats{
+			Device:     name,
+			ReadBytes:  int64(rb),
+			WriteBytes: int64(writes[name]),
+			ReadOps:    int64(readOps[name]),
+			WriteOps:   int64(writeOps[name]),
+		})
+	}
+
+	return disks, nil
+}
+
+// NetIO returns per-interface rx/tx bytes and packets from the
+// "\Network Interface(*)\..." PDH counters.
+func NetIO() ([]NetStats, error) {
+	q, err := sysPdhOpenQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	rxBytesCounter, err := sysPdhAddCounter(q, `\Network Interface(*)\Bytes Received/sec`)
+	if err != nil {
+		return nil, err
+	}
+	txBytesCounter, err := sysPdhAddCounter(q, `\Network Interface(*)\Bytes Sent/sec`)
+	if err != nil {
+		return nil, err
+	}
+	rxPacketsCounter, err := sysPdhAddCounter(q, `\Network Interface(*)\Packets Received/sec`)
+	if err != nil {
+		return nil, err
+	}
+	txPacketsCounter, err := sysPdhAddCounter(q, `\Network Interface(*)\Packets Sent/sec`)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sysPdhCollectQueryData(q); err != nil {
+		return nil, err
+	}
+	time.Sleep(pdhCollectInterval)
+	if err := sysPdhCollectQueryData(q); err != nil {
+		return nil, err
+	}
+
+	rxBytes, err := sysPdhCounterArray(rxBytesCounter)
+	if err != nil {
+		return nil, err
+	}
+	txBytes, _ := sysPdhCounterArray(txBytesCounter)
+	rxPackets, _ := sysPdhCounterArray(rxPacketsCounter)
+	txPackets, _ := sysPdhCounterArray(txPacketsCounter)
+
+	var nets []NetStats
+	for name, rb := range rxBytes {
+		if name == "_Total" {
+			continue
+		}
+		nets = append(nets, NetStats{
+			Interface: name,
+			RxBytes:   int64(rb),
+			TxBytes:   int64(txBytes[name]),
+			RxPackets: int64(rxPackets[name]),
+			TxPackets: int64(txPackets[name]),
+		})
+	}
+
+	return nets, nil
+}
@@ -1,11 +1,13 @@
 package proc
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func TestPSEmulation(t *testing.T) {
@@ -50,3 +52,59 @@ func TestPSEmulation(t *testing.T) {
 		}
 	}
 }
+
+func TestStatSelf(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skipf("Skipping this test on Windows")
+	}
+
+	st, err := Stat(os.Getpid())
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	if st.RSS <= 0 {
+		t.Fatalf("expected positive RSS, got %d", st.RSS)
+	}
+	if st.Threads <= 0 {
+		t.Fatalf("expected at least one thread, got %d", st.Threads)
+	}
+	if st.Cmdline == "" {
+		t.Fatalf("expected non-empty Cmdline")
+	}
+}
+
+func TestSampler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skipf("Skipping this test on Windows")
+	}
+
+	s := NewSampler(SamplerOpts{
+		Interval: 50 * time.Millisecond,
+		PIDs:     []int{os.Getpid()},
+		Metrics:  CPU | Memory,
+	})
+
+	sub := s.Subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	defer s.Stop()
+
+	select {
+	case sample := <-sub:
+		if sample.PID != os.Getpid() {
+			t.Fatalf("expected pid %d, got %d", os.Getpid(), sample.PID)
+		}
+		if sample.Stats.RSS <= 0 {
+			t.Fatalf("expected positive RSS, got %d", sample.Stats.RSS)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a sample")
+	}
+
+	if snap := s.Snapshot(); snap[os.Getpid()].RSS <= 0 {
+		t.Fatalf("expected snapshot to contain a sample for self, got %+v", snap)
+	}
+}
@@ -5,8 +5,6 @@ package proc
 import (
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -14,226 +12,275 @@ import (
 )
 
 var (
-	pdh                            = syscall.NewLazyDLL("pdh.dll")
-	winPdhOpenQuery                = pdh.NewProc("PdhOpenQuery")
-	winPdhAddCounter               = pdh.NewProc("PdhAddCounterW")
-	winPdhCollectQueryData         = pdh.NewProc("PdhCollectQueryData")
-	winPdhGetFormattedCounterValue = pdh.NewProc("PdhGetFormattedCounterValue")
-	winPdhGetFormattedCounterArray = pdh.NewProc("PdhGetFormattedCounterArrayW")
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+	modpsapi    = syscall.NewLazyDLL("psapi.dll")
+
+	procOpenProcess              = modkernel32.NewProc("OpenProcess")
+	procGetProcessTimes          = modkernel32.NewProc("GetProcessTimes")
+	procGetProcessIoCounters     = modkernel32.NewProc("GetProcessIoCounters")
+	procGetProcessHandleCount    = modkernel32.NewProc("GetProcessHandleCount")
+	procCreateToolhelp32Snapshot = modkernel32.NewProc("CreateToolhelp32Snapshot")
+	procProcess32First           = modkernel32.NewProc("Process32FirstW")
+	procProcess32Next            = modkernel32.NewProc("Process32NextW")
+	procGetSystemInfo            = modkernel32.NewProc("GetSystemInfo")
+	procGetProcessMemoryInfo     = modpsapi.NewProc("GetProcessMemoryInfo")
 )
 
-var (
-	pcHandle                                       PDH_HQUERY
-	pidCounter, cpuCounter, rssCounter, vssCounter PDH_HCOUNTER
-	prevCPU                                        float64
-	prevRss                                        int64
-	prevVss                                        int64
-	lastSampleTime                                 time.Time
-	processPid                                     int
-	pcQueryLock                                    sync.Mutex
-	initialSample                                  = true
+const (
+	processQueryLimitedInformation = 0x1000
+	processVMRead                  = 0x0010
+	th32csSnapProcess              = 0x00000002
+	stillActive                    = 259
 )
 
-// maxQuerySize represents the maximum servers that can be queried
-// simultaneously running on a machine.
-const maxQuerySize = 512
-
-// Keep static memory around to reuse
-var counterResults [maxQuerySize]PDH_FMT_COUNTER_VALUE_ITEM_DOUBLE
+// pcQueryLock serializes the handle-based queries below, mirroring the
+// lock the old PDH query handle required.
+var pcQueryLock sync.Mutex
+
+// systemInfo mirrors SYSTEM_INFO; only dwNumberOfProcessors is read.
+type systemInfo struct {
+	wProcessorArchitecture      uint16
+	wReserved                   uint16
+	dwPageSize                  uint32
+	lpMinimumApplicationAddress uintptr
+	lpMaximumApplicationAddress uintptr
+	dwActiveProcessorMask       uintptr
+	dwNumberOfProcessors        uint32
+	dwProcessorType             uint32
+	dwAllocationGranularity     uint32
+	wProcessorLevel             uint16
+	wProcessorRevision          uint16
+}
 
-// PDH Types
-type (
-	PDH_HQUERY   syscall.Handle
-	PDH_HCOUNTER syscall.Handle
+var (
+	numCPUOnce sync.Once
+	numCPU     int64 = 1
 )
 
-// PDH constants used here
-const (
-	PDH_FMT_DOUBLE   = 0x00000200
-	PDH_INVALID_DATA = 0xC0000BC6
-	PDH_MORE_DATA    = 0x800007D2
-)
+// getNumCPU returns NumberOfProcessors from GetSystemInfo, queried once
+// and cached since it cannot change for a running system.
+func getNumCPU() int64 {
+	numCPUOnce.Do(func() {
+		var si systemInfo
+		procGetSystemInfo.Call(uintptr(unsafe.Pointer(&si)))
+		if si.dwNumberOfProcessors > 0 {
+			numCPU = int64(si.dwNumberOfProcessors)
+		}
+	})
+	return numCPU
+}
 
-// PDH_FMT_COUNTER_VALUE_DOUBLE - double value
-type PDH_FMT_COUNTER_VALUE_DOUBLE struct {
-	CStatus     uint32
-	DoubleValue float64
+// processMemoryCountersEx mirrors PROCESS_MEMORY_COUNTERS_EX.
+type processMemoryCountersEx struct {
+	CB                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+	PrivateUsage               uintptr
 }
 
-// PDH_FMT_COUNTER_VALUE_ITEM_DOUBLE need at least 1 addressable null ptr.
-type PDH_FMT_COUNTER_VALUE_ITEM_DOUBLE struct {
-	SzName   *uint16
-	FmtValue PDH_FMT_COUNTER_VALUE_DOUBLE
+// ioCounters mirrors IO_COUNTERS.
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
 }
 
-func pdhAddCounter(hQuery PDH_HQUERY, szFullCounterPath string, dwUserData uintptr, phCounter *PDH_HCOUNTER) error {
-	ptxt, _ := syscall.UTF16PtrFromString(szFullCounterPath)
-	r0, _, _ := winPdhAddCounter.Call(
-		uintptr(hQuery),
-		uintptr(unsafe.Pointer(ptxt)),
-		dwUserData,
-		uintptr(unsafe.Pointer(phCounter)))
+// processEntry32 mirrors PROCESSENTRY32W; only ProcessID and CntThreads
+// are read.
+type processEntry32 struct {
+	Size            uint32
+	CntUsage        uint32
+	ProcessID       uint32
+	DefaultHeapID   uintptr
+	ModuleID        uint32
+	CntThreads      uint32
+	ParentProcessID uint32
+	PriClassBase    int32
+	Flags           uint32
+	ExeFile         [syscall.MAX_PATH]uint16
+}
 
-	if r0 != 0 {
-		return fmt.Errorf("pdhAddCounter failed. %d", r0)
+func openProcessHandle(pid int) (syscall.Handle, error) {
+	h, _, err := procOpenProcess.Call(
+		uintptr(processQueryLimitedInformation|processVMRead),
+		0,
+		uintptr(pid))
+	if h == 0 {
+		return 0, fmt.Errorf("OpenProcess failed for pid %d: %v", pid, err)
 	}
-	return nil
+	return syscall.Handle(h), nil
 }
 
-func pdhOpenQuery(datasrc *uint16, userdata uint32, query *PDH_HQUERY) error {
-	r0, _, _ := syscall.Syscall(winPdhOpenQuery.Addr(), 3, 0, uintptr(userdata), uintptr(unsafe.Pointer(query)))
-	if r0 != 0 {
-		return fmt.Errorf("pdhOpenQuery failed - %d", r0)
+func getProcessTimes(h syscall.Handle) (creation, exit, kernel, user syscall.Filetime, err error) {
+	r, _, e := procGetProcessTimes.Call(
+		uintptr(h),
+		uintptr(unsafe.Pointer(&creation)),
+		uintptr(unsafe.Pointer(&exit)),
+		uintptr(unsafe.Pointer(&kernel)),
+		uintptr(unsafe.Pointer(&user)))
+	if r == 0 {
+		err = fmt.Errorf("GetProcessTimes failed: %v", e)
 	}
-	return nil
+	return
 }
 
-func pdhCollectQueryData(hQuery PDH_HQUERY) error {
-	r0, _, _ := winPdhCollectQueryData.Call(uintptr(hQuery))
-	if r0 != 0 {
-		return fmt.Errorf("pdhCollectQueryData failed - %d", r0)
+func getProcessMemoryInfo(h syscall.Handle) (processMemoryCountersEx, error) {
+	var mc processMemoryCountersEx
+	mc.CB = uint32(unsafe.Sizeof(mc))
+	r, _, e := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mc)), uintptr(mc.CB))
+	if r == 0 {
+		return mc, fmt.Errorf("GetProcessMemoryInfo failed: %v", e)
 	}
-	return nil
+	return mc, nil
 }
 
-func pdhGetFormattedCounterArrayDouble(hCounter PDH_HCOUNTER, lpdwBufferSize *uint32, lpdwBufferCount *uint32, itemBuffer *PDH_FMT_COUNTER_VALUE_ITEM_DOUBLE) uint32 {
-	ret, _, _ := winPdhGetFormattedCounterArray.Call(
-		uintptr(hCounter),
-		uintptr(PDH_FMT_DOUBLE),
-		uintptr(unsafe.Pointer(lpdwBufferSize)),
-		uintptr(unsafe.Pointer(lpdwBufferCount)),
-		uintptr(unsafe.Pointer(itemBuffer)))
-
-	return uint32(ret)
+func getProcessIoCounters(h syscall.Handle) (ioCounters, error) {
+	var io ioCounters
+	r, _, e := procGetProcessIoCounters.Call(uintptr(h), uintptr(unsafe.Pointer(&io)))
+	if r == 0 {
+		return io, fmt.Errorf("GetProcessIoCounters failed: %v", e)
+	}
+	return io, nil
 }
 
-func getCounterArrayData(counter PDH_HCOUNTER) ([]float64, error) {
-	var bufSize uint32
-	var bufCount uint32
-
-	initialBuf := make([]PDH_FMT_COUNTER_VALUE_ITEM_DOUBLE, 1)
-	ret := pdhGetFormattedCounterArrayDouble(counter, &bufSize, &bufCount, &initialBuf[0])
-	if ret == PDH_MORE_DATA {
-		if bufCount > maxQuerySize {
-			bufCount = maxQuerySize
-		}
-		ret = pdhGetFormattedCounterArrayDouble(counter, &bufSize, &bufCount, &counterResults[0])
-		if ret == 0 {
-			rv := make([]float64, bufCount)
-			for i := 0; i < int(bufCount); i++ {
-				rv[i] = counterResults[i].FmtValue.DoubleValue
-			}
-			return rv, nil
-		}
+func getProcessHandleCount(h syscall.Handle) (int, error) {
+	var count uint32
+	r, _, e := procGetProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&count)))
+	if r == 0 {
+		return 0, fmt.Errorf("GetProcessHandleCount failed: %v", e)
 	}
-	if ret != 0 {
-		return nil, fmt.Errorf("getCounterArrayData failed - %d", ret)
-	}
-
-	return nil, nil
+	return int(count), nil
 }
 
-// getProcessExeName returns the name of the process image, as expected by
-// the performance counter API.
-func getProcessExeName() (name string) {
-	name = filepath.Base(os.Args[0])
-	name = strings.TrimRight(name, ".exe")
-	return
-}
+// getThreadCount walks a process snapshot looking for pid, since
+// GetProcessTimes/GetProcessMemoryInfo have no thread-count equivalent.
+func getThreadCount(pid int) (int, error) {
+	snap, _, e := procCreateToolhelp32Snapshot.Call(uintptr(th32csSnapProcess), 0)
+	if snap == uintptr(syscall.InvalidHandle) {
+		return 0, fmt.Errorf("CreateToolhelp32Snapshot failed: %v", e)
+	}
+	defer syscall.CloseHandle(syscall.Handle(snap))
 
-// initialize counters
-func initCounters() (err error) {
+	var entry processEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
 
-	processPid = os.Getpid()
-	var source uint16
-	if err := pdhOpenQuery(&source, 0, &pcHandle); err != nil {
-		return err
+	r, _, _ := procProcess32First.Call(snap, uintptr(unsafe.Pointer(&entry)))
+	for r != 0 {
+		if int(entry.ProcessID) == pid {
+			return int(entry.CntThreads), nil
+		}
+		r, _, _ = procProcess32Next.Call(snap, uintptr(unsafe.Pointer(&entry)))
 	}
+	return 0, fmt.Errorf("pid %d not found in process snapshot", pid)
+}
 
-	name := fmt.Sprintf("%s*", getProcessExeName())
-	pidQuery := fmt.Sprintf("\\Process(%s)\\ID Process", name)
-	cpuQuery := fmt.Sprintf("\\Process(%s)\\%% Processor Time", name)
-	rssQuery := fmt.Sprintf("\\Process(%s)\\Working Set - Private", name)
-	vssQuery := fmt.Sprintf("\\Process(%s)\\Virtual Bytes", name)
+func filetimeKey(ft syscall.Filetime) int64 {
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
 
-	if err = pdhAddCounter(pcHandle, pidQuery, 0, &pidCounter); err != nil {
-		return err
-	}
-	if err = pdhAddCounter(pcHandle, cpuQuery, 0, &cpuCounter); err != nil {
-		return err
-	}
-	if err = pdhAddCounter(pcHandle, rssQuery, 0, &rssCounter); err != nil {
-		return err
-	}
-	if err = pdhAddCounter(pcHandle, vssQuery, 0, &vssCounter); err != nil {
+// Usage returns CPU and Memory usage
+func Usage(pcpu *float64, rss, vss *int64) error {
+	st, err := Stat(os.Getpid())
+	if err != nil {
 		return err
 	}
 
-	if err = pdhCollectQueryData(pcHandle); err != nil {
-		return err
-	}
-	time.Sleep(50)
+	*pcpu = st.PercentCPU
+	*rss = st.RSS
+	*vss = st.VSS
 
 	return nil
 }
 
-// Usage returns process CPU and memory
-func Usage(pcpu *float64, rss, vss *int64) error {
-	var err error
+// Stat returns extended process metrics for pid, gathered from a
+// PROCESS_QUERY_LIMITED_INFORMATION|PROCESS_VM_READ handle rather than
+// the PDH performance counter API, so it works for any pid without
+// relying on image-name matching.
+func Stat(pid int) (ProcessStats, error) {
+	return statSource(pid, directSample)
+}
+
+// statSource is Stat, parameterized over the pcpuFromSample keyspace so
+// a Sampler can poll the same pid without perturbing the measurement
+// window a direct Stat/Usage caller for that pid is relying on.
+func statSource(pid int, source sampleSource) (ProcessStats, error) {
+	var st ProcessStats
 
 	pcQueryLock.Lock()
 	defer pcQueryLock.Unlock()
 
-	if initialSample {
-		if err = initCounters(); err != nil {
-			return err
-		}
-		initialSample = false
-	} else if time.Since(lastSampleTime) < (2 * time.Second) {
-		*pcpu = prevCPU
-		*rss = prevRss
-		*vss = prevVss
-		return nil
+	h, err := openProcessHandle(pid)
+	if err != nil {
+		return st, err
 	}
+	defer syscall.CloseHandle(h)
 
-	defer func() {
-		lastSampleTime = time.Now()
-	}()
-
-	if err = pdhCollectQueryData(pcHandle); err != nil {
-		return err
+	creation, _, kernel, user, err := getProcessTimes(h)
+	if err != nil {
+		return st, err
 	}
 
-	var pidAry, cpuAry, rssAry, vssAry []float64
-	if pidAry, err = getCounterArrayData(pidCounter); err != nil {
-		return err
+	mc, err := getProcessMemoryInfo(h)
+	if err != nil {
+		return st, err
 	}
-	if cpuAry, err = getCounterArrayData(cpuCounter); err != nil {
-		return err
-	}
-	if rssAry, err = getCounterArrayData(rssCounter); err != nil {
-		return err
-	}
-	if vssAry, err = getCounterArrayData(vssCounter); err != nil {
-		return err
-	}
-	idx := int(-1)
-	for i := range pidAry {
-		if int(pidAry[i]) == processPid {
-			idx = i
-			break
-		}
+
+	io, _ := getProcessIoCounters(h)
+	handles, _ := getProcessHandleCount(h)
+	threads, _ := getThreadCount(pid)
+
+	// user/kernel are elapsed durations, not absolute times, so unlike
+	// creation they must not go through Nanoseconds() (which subtracts
+	// the 1601->1970 epoch offset and overflows for small durations).
+	// FILETIME ticks are 100ns units.
+	st.CPUUserTime = time.Duration(filetimeKey(user)*100) * time.Nanosecond
+	st.CPUSystemTime = time.Duration(filetimeKey(kernel)*100) * time.Nanosecond
+	st.RSS = int64(mc.WorkingSetSize)
+	st.VSS = int64(mc.PrivateUsage)
+	st.Threads = threads
+	st.OpenFDs = handles
+	st.IORead = int64(io.ReadTransferCount)
+	st.IOWrite = int64(io.WriteTransferCount)
+	st.StartTime = time.Unix(0, creation.Nanoseconds())
+
+	if pid == os.Getpid() {
+		st.Cmdline = joinArgs(os.Args)
 	}
-	if idx < 0 {
-		return fmt.Errorf("could not find pid in performance counter results")
+
+	var exitCode uint32
+	if err := syscall.GetExitCodeProcess(h, &exitCode); err == nil && exitCode == stillActive {
+		st.State = "R"
+	} else {
+		st.State = "Z"
 	}
-	*pcpu = cpuAry[idx]
-	*rss = int64(rssAry[idx])
-	*vss = int64(vssAry[idx])
 
-	prevCPU = *pcpu
-	prevRss = *rss
-	prevVss = *vss
+	// Normalize by NumberOfProcessors so a process pegging one core on an
+	// N-core machine reads ~100/N%, matching Task Manager rather than ps.
+	// Same caveat as CPUUserTime/CPUSystemTime above: use the raw tick
+	// count, not Nanoseconds().
+	total := filetimeKey(user)*100 + filetimeKey(kernel)*100
+	st.PercentCPU = float64(pcpuFromSample(source, pid, filetimeKey(creation), total)) / 10.0 / float64(getNumCPU())
 
-	return nil
+	return st, nil
+}
+
+func joinArgs(args []string) string {
+	out := ""
+	for i, a := range args {
+		if i > 0 {
+			out += " "
+		}
+		out += a
+	}
+	return out
 }
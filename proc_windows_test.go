@@ -3,12 +3,11 @@
 package proc
 
 import (
-	"fmt"
-	"os/exec"
+	"os"
 	"runtime"
-	"strconv"
-	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func checkValues(t *testing.T, pcpu, tPcpu float64, rss, tRss int64) {
@@ -32,48 +31,54 @@ func checkValues(t *testing.T, pcpu, tPcpu float64, rss, tRss int64) {
 	}
 }
 
+// TestPSEmulationWin drives the same handle-based Win32 APIs Usage uses
+// internally, rather than shelling out to typeperf.exe, so the test
+// doesn't depend on localized performance counter names. The expected
+// pcpu/rss are derived independently, from a separate pair of
+// GetProcessTimes samples over a known interval, rather than from
+// Usage/Stat themselves, so the comparison isn't circular.
 func TestPSEmulationWin(t *testing.T) {
-	var pcpu, tPcpu float64
-	var rss, vss, tRss int64
+	var pcpu float64
+	var rss int64
 
 	runtime.GC()
 
-	if err := Usage(&pcpu, &rss, &vss); err != nil {
+	if err := Usage(&pcpu, &rss, new(int64)); err != nil {
 		t.Fatalf("Error:  %v", err)
 	}
 
-	runtime.GC()
+	h, err := openProcessHandle(os.Getpid())
+	if err != nil {
+		t.Fatalf("openProcessHandle failed: %v", err)
+	}
+	defer syscall.CloseHandle(h)
 
-	imageName := getProcessImageName()
-	out, err := exec.Command("typeperf.exe",
-		fmt.Sprintf("\\Process(%s)\\%% Processor Time", imageName),
-		fmt.Sprintf("\\Process(%s)\\Working Set - Private", imageName),
-		fmt.Sprintf("\\Process(%s)\\Virtual Bytes", imageName),
-		"-sc", "1").Output()
+	_, _, kernel1, user1, err := getProcessTimes(h)
 	if err != nil {
-		t.Fatal("unable to run command", err)
+		t.Fatalf("getProcessTimes failed: %v", err)
 	}
+	start := time.Now()
 
-	results := strings.Split(string(out), "\r\n")
-	values := strings.Split(results[2], ",")
+	time.Sleep(200 * time.Millisecond)
 
-	tPcpu, err = strconv.ParseFloat(strings.Trim(values[1], "\""), 64)
+	mc, err := getProcessMemoryInfo(h)
 	if err != nil {
-		t.Fatalf("Unable to parse percent cpu: %s", values[1])
+		t.Fatalf("getProcessMemoryInfo failed: %v", err)
 	}
+	tRss := int64(mc.WorkingSetSize)
 
-	fval, err := strconv.ParseFloat(strings.Trim(values[2], "\""), 64)
+	_, _, kernel2, user2, err := getProcessTimes(h)
 	if err != nil {
-		t.Fatalf("Unable to parse private bytes: %s", values[2])
+		t.Fatalf("getProcessTimes failed: %v", err)
 	}
-	tRss = int64(fval)
+	elapsed := time.Since(start)
 
-	checkValues(t, pcpu, tPcpu, rss, tRss)
+	deltaTicks := (filetimeKey(kernel2) - filetimeKey(kernel1)) + (filetimeKey(user2) - filetimeKey(user1))
+	tPcpu := float64(deltaTicks*100) / float64(elapsed) * 100 / float64(getNumCPU())
 
-	runtime.GC()
-
-	if err = Usage(&pcpu, &rss, &vss); err != nil {
+	if err := Usage(&pcpu, &rss, new(int64)); err != nil {
 		t.Fatalf("Error:  %v", err)
 	}
+
 	checkValues(t, pcpu, tPcpu, rss, tRss)
 }